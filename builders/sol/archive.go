@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archiveEntry is the (name, mode, size, content hash) tuple used to build a
+// cache key for an archive's contents, independent of the order its files
+// were read or uploaded in.
+type archiveEntry struct {
+	name string
+	mode int64
+	size int64
+	sha  string
+}
+
+// hashEntries combines a set of archive entries with the platform and tags
+// they were built for into a single cache key, stable regardless of the
+// order entries were discovered in.
+func hashEntries(entries []archiveEntry, platform string, tags []string) string {
+	sorted := append([]archiveEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+
+	sortedTags := append([]string(nil), tags...)
+	sort.Strings(sortedTags)
+
+	h := sha256.New()
+	for _, e := range sorted {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%s\n", e.name, e.mode, e.size, e.sha)
+	}
+	fmt.Fprintf(h, "platform=%s\n", platform)
+	for _, t := range sortedTags {
+		fmt.Fprintf(h, "tag=%s\n", t)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// computeArchiveEntries stats and (for regular files) hashes files without
+// writing an archive, so the client can compute the cache key for a
+// potential upload before paying to build and send it.
+func computeArchiveEntries(files []string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+
+	for _, name := range files {
+		lfi, err := os.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := int64(lfi.Mode().Perm())
+
+		switch {
+		case lfi.Mode()&os.ModeSymlink != 0:
+			linkname, err := os.Readlink(name)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: name, mode: mode, sha: sha256hex(linkname)})
+
+		case lfi.Mode().IsRegular():
+			f, err := os.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: name, mode: mode, size: lfi.Size(), sha: hex.EncodeToString(h.Sum(nil))})
+
+		default:
+			entries = append(entries, archiveEntry{name: name, mode: mode})
+		}
+	}
+
+	return entries, nil
+}
+
+// sanitizeExtractPath joins name onto dir and makes sure the result is
+// still contained in dir, rejecting absolute paths and any ".." escape.
+func sanitizeExtractPath(dir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("refusing to extract absolute path %q", name)
+	}
+
+	target := filepath.Clean(filepath.Join(dir, name))
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%q escapes the extraction directory", name)
+	}
+
+	return target, nil
+}
+
+// resolveLinkTarget resolves a (possibly relative) link target against the
+// directory containing the link and checks that it stays inside root.
+func resolveLinkTarget(root, linkDir, linkname string) (string, error) {
+	if filepath.IsAbs(linkname) {
+		return "", fmt.Errorf("refusing to link to absolute path %q", linkname)
+	}
+
+	target := filepath.Clean(filepath.Join(linkDir, linkname))
+	if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("link target %q escapes the extraction directory", linkname)
+	}
+
+	return target, nil
+}
+
+// extractLimits bounds how much an extractTar call is willing to write, so
+// that a malicious or buggy archive can't exhaust the server's disk.
+type extractLimits struct {
+	maxFileBytes  int64
+	maxTotalBytes int64
+}
+
+// extractTar reads a (decompressed) tar stream from tr and writes it below
+// dir, rejecting anything that would place a file outside of dir. It
+// returns the (name, mode, size, content hash) tuple for every entry seen,
+// for use as a cache key.
+func extractTar(tr *tar.Reader, dir string, limits extractLimits) ([]archiveEntry, error) {
+	var total int64
+	var entries []archiveEntry
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := sanitizeExtractPath(dir, header.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)&os.ModePerm); err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: header.Name, mode: header.Mode})
+
+		case tar.TypeReg:
+			if header.Size > limits.maxFileBytes {
+				return nil, fmt.Errorf("%q is %d bytes, over the %d byte per-file limit", header.Name, header.Size, limits.maxFileBytes)
+			}
+			total += header.Size
+			if total > limits.maxTotalBytes {
+				return nil, fmt.Errorf("archive exceeds the %d byte total extraction limit", limits.maxTotalBytes)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode)&os.ModePerm)
+			if err != nil {
+				return nil, err
+			}
+			h := sha256.New()
+			if _, err := io.CopyN(io.MultiWriter(f, h), tr, header.Size); err != nil && err != io.EOF {
+				f.Close()
+				return nil, err
+			}
+			f.Close()
+			entries = append(entries, archiveEntry{name: header.Name, mode: header.Mode, size: header.Size, sha: hex.EncodeToString(h.Sum(nil))})
+
+		case tar.TypeSymlink:
+			linkTarget, err := resolveLinkTarget(dir, filepath.Dir(target), header.Linkname)
+			if err != nil {
+				return nil, err
+			}
+			_ = linkTarget // only used to validate containment; symlink is written relative below
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: header.Name, mode: header.Mode, sha: sha256hex(header.Linkname)})
+
+		case tar.TypeLink:
+			linkTarget, err := resolveLinkTarget(dir, dir, header.Linkname)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: header.Name, mode: header.Mode, sha: sha256hex(header.Linkname)})
+
+		default:
+			// Ignore anything else (devices, fifos, ...); they have no
+			// business being in a source upload.
+		}
+	}
+}
+
+// extractArchive decompresses a gzip'd tar stream from r and extracts it
+// below dir using extractTar's sandboxing rules, returning the same entry
+// tuples extractTar does.
+func extractArchive(r io.Reader, dir string, limits extractLimits) ([]archiveEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open gzip stream: %s", err)
+	}
+	defer gz.Close()
+
+	return extractTar(tar.NewReader(gz), dir, limits)
+}
+
+func sha256hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateSymlinkTarget rejects symlinks whose target would resolve outside
+// of root once placed at name, mirroring the checks extractTar applies on
+// the way in.
+func validateSymlinkTarget(root, name, linkname string) error {
+	_, err := resolveLinkTarget(root, filepath.Dir(name), linkname)
+	return err
+}
+
+// writeArchive tars up files (relative to the current directory) into w,
+// gzip-compressed, applying the same symlink containment rules the server
+// enforces on extraction. It returns the same entry tuples the server
+// computes on extraction, so the client can derive a matching cache key
+// without a second pass over the filesystem.
+func writeArchive(files []string, w io.Writer) ([]archiveEntry, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	var entries []archiveEntry
+
+	for _, name := range files {
+		lfi, err := os.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := tar.FileInfoHeader(lfi, "")
+		if err != nil {
+			return nil, err
+		}
+		header.Name = name
+
+		if lfi.Mode()&os.ModeSymlink != 0 {
+			linkname, err := os.Readlink(name)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateSymlinkTarget(initialDir, filepath.Join(initialDir, name), linkname); err != nil {
+				return nil, fmt.Errorf("couldn't archive %q: %s", name, err)
+			}
+			header.Linkname = linkname
+			if err := tw.WriteHeader(header); err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: name, mode: header.Mode, sha: sha256hex(linkname)})
+			continue
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+
+		if lfi.Mode().IsRegular() {
+			f, err := os.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			h := sha256.New()
+			_, err = io.Copy(io.MultiWriter(tw, h), f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{name: name, mode: header.Mode, size: header.Size, sha: hex.EncodeToString(h.Sum(nil))})
+		} else {
+			entries = append(entries, archiveEntry{name: name, mode: header.Mode})
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeExtractPath(t *testing.T) {
+	dir := "/srv/extract"
+
+	cases := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"plain file", "foo.txt", false},
+		{"nested file", "a/b/c.txt", false},
+		{"absolute path", "/etc/passwd", true},
+		{"parent escape", "../../../etc/passwd", true},
+		{"escape via nested dotdot", "a/../../b", true},
+		{"dotdot that stays inside", "a/../b", false},
+		{"bare dotdot", "..", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := sanitizeExtractPath(dir, tc.path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("sanitizeExtractPath(%q, %q): err = %v, wantErr = %v", dir, tc.path, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveLinkTarget(t *testing.T) {
+	root := "/srv/extract"
+
+	cases := []struct {
+		name     string
+		linkDir  string
+		linkname string
+		wantErr  bool
+	}{
+		{"sibling file", root, "sibling.txt", false},
+		{"relative into subdir", root, "sub/file.txt", false},
+		{"absolute target", root, "/etc/passwd", true},
+		{"escape to parent", root, "../../etc/passwd", true},
+		{"escape then return stays inside", root + "/a", "../a/file.txt", false},
+		{"escape from nested dir", root + "/a/b", "../../../../etc/passwd", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := resolveLinkTarget(root, tc.linkDir, tc.linkname)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("resolveLinkTarget(%q, %q, %q): err = %v, wantErr = %v", root, tc.linkDir, tc.linkname, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSymlinkTargetChain(t *testing.T) {
+	root := "/srv/extract"
+
+	// A symlink at root/a/link pointing back up and then into a sibling
+	// directory should still resolve inside root.
+	if err := validateSymlinkTarget(root, root+"/a/link", "../b/file.txt"); err != nil {
+		t.Fatalf("expected in-root symlink chain to be accepted, got %v", err)
+	}
+
+	// The same shape, but with one extra ".." hop, escapes root.
+	if err := validateSymlinkTarget(root, root+"/a/link", "../../b/file.txt"); err == nil {
+		t.Fatal("expected escaping symlink chain to be rejected")
+	}
+}
+
+// TestWriteArchiveOrdinarySymlink exercises writeArchive the way pickFiles
+// actually calls it: with names relative to initialDir, not prefixed by it.
+// A plain same-directory symlink must archive cleanly, not be rejected as
+// escaping the tree it plainly stays inside.
+func TestWriteArchiveOrdinarySymlink(t *testing.T) {
+	dir := t.TempDir()
+
+	oldInitialDir := initialDir
+	oldWD, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	initialDir = dir
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		initialDir = oldInitialDir
+		os.Chdir(oldWD)
+	}()
+
+	if err := os.WriteFile(filepath.Join(dir, "target.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := writeArchive([]string{"target.txt", "link"}, &buf); err != nil {
+		t.Fatalf("writeArchive rejected an ordinary same-directory symlink: %v", err)
+	}
+}
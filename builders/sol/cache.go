@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildCache stores finished output.zip artifacts on disk keyed by the
+// SHA-256 of their inputs (see hashEntries), so an unchanged upload can
+// skip straight to the artifact instead of recompiling. A nil *buildCache
+// means caching is disabled.
+type buildCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+func newBuildCache(dir string, maxBytes int64) *buildCache {
+	if dir == "" {
+		return nil
+	}
+	os.MkdirAll(dir, 0755)
+	return &buildCache{dir: dir, maxBytes: maxBytes}
+}
+
+func (c *buildCache) entryDir(sha string) string {
+	return filepath.Join(c.dir, sha)
+}
+
+func (c *buildCache) artifactPath(sha string) string {
+	return filepath.Join(c.entryDir(sha), "output.zip")
+}
+
+// lookup returns the path to a cached artifact for sha, if present.
+func (c *buildCache) lookup(sha string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	path := c.artifactPath(sha)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+
+	touchFile(path)
+	return path, true
+}
+
+// store atomically moves a freshly built artifact into the cache under sha.
+func (c *buildCache) store(sha, artifact string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entryDir := c.entryDir(sha)
+	if err := os.MkdirAll(entryDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(entryDir, "output-*.zip")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	src, err := os.Open(artifact)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	_, err = io.Copy(tmp, src)
+	src.Close()
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, c.artifactPath(sha)); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	c.evict()
+	return nil
+}
+
+type cacheEntryInfo struct {
+	sha     string
+	size    int64
+	modTime int64
+}
+
+func (c *buildCache) entries() []cacheEntryInfo {
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+
+	var out []cacheEntryInfo
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		artifact, err := os.Stat(c.artifactPath(fi.Name()))
+		if err != nil {
+			continue
+		}
+		out = append(out, cacheEntryInfo{sha: fi.Name(), size: artifact.Size(), modTime: artifact.ModTime().UnixNano()})
+	}
+	return out
+}
+
+// evict deletes the least-recently-used entries until the cache is back
+// under its configured size, a straightforward size-bounded LRU policy.
+func (c *buildCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	entries := c.entries()
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime < entries[j].modTime })
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		os.RemoveAll(c.entryDir(e.sha))
+		total -= e.size
+	}
+}
+
+func touchFile(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+func handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if cache == nil {
+		fmt.Fprintln(w, "cache disabled")
+		return
+	}
+
+	entries := cache.entries()
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	fmt.Fprintf(w, "entries %d\n", len(entries))
+	fmt.Fprintf(w, "bytes %d\n", total)
+	fmt.Fprintf(w, "max-bytes %d\n", cache.maxBytes)
+}
+
+// handleCache dispatches /cache/{sha}/artifact, letting a client that
+// already knows an upload's content hash fetch the cached result directly.
+func handleCache(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/cache/")
+	parts := strings.SplitN(path, "/", 2)
+
+	if len(parts) != 2 || parts[1] != "artifact" {
+		http.NotFound(w, nil)
+		return
+	}
+
+	handleCacheArtifact(w, parts[0])
+}
+
+// handleCacheArtifact serves a cached artifact directly by its content
+// hash, letting a client with a local cache hit skip uploading entirely.
+func handleCacheArtifact(w http.ResponseWriter, sha string) {
+	path, ok := cache.lookup(sha)
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+
+	bin, err := os.Open(path)
+	if err != nil {
+		internalError(w, "couldn't open cached artifact: %s", err)
+		return
+	}
+	defer bin.Close()
+
+	stat, _ := bin.Stat()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
+	io.Copy(w, bin)
+}
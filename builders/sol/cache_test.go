@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTempArtifact(t *testing.T, dir string, size int) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile(dir, "artifact-*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestBuildCacheStoreAndLookup(t *testing.T) {
+	dir := t.TempDir()
+	c := newBuildCache(dir, 0)
+
+	src := writeTempArtifact(t, dir, 128)
+	if err := c.store("sha-a", src); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	path, ok := c.lookup("sha-a")
+	if !ok {
+		t.Fatal("expected lookup to find the stored artifact")
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat cached artifact: %v", err)
+	}
+	if stat.Size() != 128 {
+		t.Fatalf("cached artifact size = %d, want 128", stat.Size())
+	}
+
+	if _, ok := c.lookup("sha-missing"); ok {
+		t.Fatal("expected lookup of an unknown sha to miss")
+	}
+}
+
+func TestNewBuildCacheDisabledWhenDirEmpty(t *testing.T) {
+	c := newBuildCache("", 0)
+	if c != nil {
+		t.Fatal("expected a nil *buildCache when dir is empty")
+	}
+
+	// nil-safe methods: must not panic and must behave as "no cache".
+	if _, ok := c.lookup("anything"); ok {
+		t.Fatal("expected lookup on a nil cache to always miss")
+	}
+	if err := c.store("sha", "/does/not/matter"); err != nil {
+		t.Fatalf("expected store on a nil cache to no-op, got %v", err)
+	}
+}
+
+func TestBuildCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c := newBuildCache(dir, 250)
+
+	store := func(sha string, size int, age time.Duration) {
+		src := writeTempArtifact(t, dir, size)
+		if err := c.store(sha, src); err != nil {
+			t.Fatalf("store %s: %v", sha, err)
+		}
+		// Force a specific mtime so eviction order is deterministic,
+		// rather than relying on real-clock gaps between store() calls.
+		old := time.Now().Add(-age)
+		if err := os.Chtimes(c.artifactPath(sha), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store("oldest", 100, 3*time.Hour)
+	store("middle", 100, 2*time.Hour)
+	store("newest", 100, 1*time.Hour)
+
+	// Total is 300 bytes, over the 250 byte cap: evict() should run and
+	// drop "oldest" (and only "oldest") to get back under the cap.
+	c.evict()
+
+	if _, ok := c.lookup("oldest"); ok {
+		t.Fatal("expected the least-recently-used entry to be evicted")
+	}
+	if _, ok := c.lookup("middle"); !ok {
+		t.Fatal("expected a more recently used entry to survive eviction")
+	}
+	if _, ok := c.lookup("newest"); !ok {
+		t.Fatal("expected the most recently used entry to survive eviction")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "oldest")); !os.IsNotExist(err) {
+		t.Fatalf("expected evicted entry's directory to be removed, stat err = %v", err)
+	}
+}
+
+func TestBuildCacheEvictDisabledWhenMaxBytesZero(t *testing.T) {
+	dir := t.TempDir()
+	c := newBuildCache(dir, 0)
+
+	store := func(sha string) {
+		src := writeTempArtifact(t, dir, 1024)
+		if err := c.store(sha, src); err != nil {
+			t.Fatalf("store %s: %v", sha, err)
+		}
+	}
+	store("a")
+	store("b")
+
+	if _, ok := c.lookup("a"); !ok {
+		t.Fatal("expected entries to be kept when maxBytes <= 0 disables eviction")
+	}
+	if _, ok := c.lookup("b"); !ok {
+		t.Fatal("expected entries to be kept when maxBytes <= 0 disables eviction")
+	}
+}
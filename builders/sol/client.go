@@ -1,7 +1,6 @@
 package main
 
 import (
-	"archive/tar"
 	"bytes"
 	"flag"
 	"fmt"
@@ -10,8 +9,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 )
 
 var targetPlatform = ""
@@ -19,7 +18,7 @@ var targetPlats []string
 var targetTags []string
 
 func runClient() {
-	if flag.NArg() == 0 {
+	if flag.NArg() == 0 && !*buildAll {
 		writeList(os.Stdout)
 		return
 	}
@@ -44,21 +43,32 @@ func runClient() {
 		}
 	}
 
-	switch len(candidates) {
-	case 0:
-		log.Fatal("Must pass a platform as argument")
-	case 1:
-		targetPlatform = candidates[0]
-	default:
-		log.Fatal("Multiple platforms set in the command line: ", candidates)
+	if *buildAll {
+		candidates = nil
+		for _, target := range availableTargets {
+			candidates = append(candidates, target[9:])
+		}
 	}
 
-	targetPlats = strings.Split(targetPlatform, "-")
+	switch {
+	case len(candidates) == 0:
+		log.Fatal("Must pass a platform as argument, or --all")
+
+	case len(candidates) == 1 && !*buildAll:
+		targetPlatform = candidates[0]
+		targetPlats = strings.Split(targetPlatform, "-")
+
+		fmt.Println("target platform:", targetPlatform)
+		fmt.Println("target tags:", targetTags)
+
+		runBuild()
 
-	fmt.Println("target platform:", targetPlatform)
-	fmt.Println("target tags:", targetTags)
+	default:
+		fmt.Println("target platforms:", candidates)
+		fmt.Println("target tags:", targetTags)
 
-	runBuild()
+		runMultiBuild(candidates, targetTags)
+	}
 }
 
 func getList() []string {
@@ -86,29 +96,6 @@ func writeList(w io.Writer) {
 	io.Copy(w, resp.Body)
 }
 
-func writeArchive(files []string, w io.Writer) error {
-	tw := tar.NewWriter(w)
-
-	for _, name := range files {
-		f, err := os.Open(name)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fi, err := f.Stat()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		header, _ := tar.FileInfoHeader(fi, "")
-		header.Name = name
-		tw.WriteHeader(header)
-		io.Copy(tw, f)
-	}
-
-	tw.Close()
-	return nil
-}
-
 func isTag(name string) bool {
 	return strings.HasPrefix(name, "tag-")
 }
@@ -162,60 +149,272 @@ func pickFiles(tags []string) []string {
 	return files
 }
 
+// platformWantsDir reports whether target (a platform name like pickFiles'
+// targetPlatform) would keep a plat-<plats...> directory, i.e. every part
+// of plats is one of target's own dash-separated components.
+func platformWantsDir(plats []string, target string) bool {
+	targetPlats := strings.Split(target, "-")
+	for _, p := range plats {
+		if !contains(p, targetPlats) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickFilesForTargets is like pickFiles but for a whole set of target
+// platforms at once: a plat-<name> directory is kept if at least one of
+// platforms would have kept it, so one walk can build a single archive
+// shared across every target's build request.
+func pickFilesForTargets(platforms []string, tags []string) []string {
+	var files []string
+
+	walkFunc := func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(initialDir, path)
+		if strings.HasPrefix(rel, ".") || strings.HasPrefix(rel, "out") {
+			return nil
+		}
+
+		if strings.HasPrefix(rel, "Makefile") {
+			fmt.Println("Ignoring", rel)
+			return nil
+		}
+
+		base := info.Name()
+
+		if info.IsDir() {
+			if isTag(base) {
+				if !contains(base, tags) {
+					return filepath.SkipDir
+				}
+
+			} else if strings.HasPrefix(base, "plat-") {
+				plats := strings.Split(base[5:], "-")
+				keep := false
+				for _, target := range platforms {
+					if platformWantsDir(plats, target) {
+						keep = true
+						break
+					}
+				}
+				if !keep {
+					return filepath.SkipDir
+				}
+			}
+		}
+
+		files = append(files, rel)
+		return nil
+	}
+
+	filepath.Walk(initialDir, walkFunc)
+	return files
+}
+
 func runBuild() {
 	files := pickFiles(targetTags)
 
 	fmt.Println("Files picked:", files)
 
-	var buf bytes.Buffer
-	err := writeArchive(files, &buf)
+	localEntries, err := computeArchiveEntries(files)
 	if err != nil {
+		log.Fatal("Couldn't hash the files: ", err)
+	}
+	sha := hashEntries(localEntries, targetPlatform, targetTags)
+
+	if fetchCachedArtifact(sha, targetPlatform) {
+		fmt.Println("cache hit, skipped upload:", sha)
+		return
+	}
+
+	var buf bytes.Buffer
+	if _, err := writeArchive(files, &buf); err != nil {
 		log.Fatal("Couldn't archive the files")
 	}
 
-	// Send platform as part of the request!
-	resp, err := http.Post("http://"+*connect+":2222/build/platform-"+targetPlatform, "application/octet-stream", &buf)
+	uploadPool := newBarPool()
+	uploadBar := uploadPool.add("upload", int64(buf.Len()))
+	id := submitJob(targetPlatform, &buf, uploadBar)
+	uploadPool.stopAndWait()
+	fmt.Println("job:", id)
+
+	if err := followJobLog(id, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+
+	status, exitCode, err := fetchJobStatus(id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("status:", status, "exit code:", exitCode)
+
+	if status != "succeeded" {
+		log.Fatal("Build failed")
+	}
+
+	downloadPool := newBarPool()
+	downloadJobArtifact(id, targetPlatform, downloadPool)
+	downloadPool.stopAndWait()
+}
+
+// fetchCachedArtifact checks whether the server already has a build cached
+// for sha and, if so, downloads it straight to out/<platform>.zip without
+// ever uploading the archive.
+func fetchCachedArtifact(sha, platform string) bool {
+	resp, err := http.Get("http://" + *connect + ":2222/cache/" + sha + "/artifact")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer resp.Body.Close()
 
-	contentType := resp.Header["Content-Type"][0]
+	if resp.StatusCode == http.StatusNotFound {
+		return false
+	}
 
-	if strings.HasPrefix(contentType, "text/plain") {
-		io.Copy(os.Stdout, resp.Body)
-	} else if contentType == "application/octet-stream" {
-		os.MkdirAll("out", 0755)
-		bin, err := os.Create("out/" + targetPlatform + ".zip")
-		if err != nil {
-			log.Fatal(err)
-		}
+	pool := newBarPool()
+	bar := pool.add("download", resp.ContentLength)
+	body := &countingReader{r: resp.Body, bar: bar}
 
-		c := make(chan struct{})
-		go func() {
-			io.Copy(bin, resp.Body)
-			close(c)
-		}()
-
-		done := false
-		for {
-			select {
-			case <-c:
-				fmt.Println("DONE")
-				done = true
-			case <-time.After(3 * time.Second):
-				stat, _ := bin.Stat()
-				fmt.Printf("%.2f%%\n", (float64(stat.Size())/float64(resp.ContentLength))*100)
-			}
-			if done {
-				break
-			}
-		}
+	os.MkdirAll("out", 0755)
+	bin, err := os.Create("out/" + platform + ".zip")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bin.Close()
+
+	if _, err := io.Copy(bin, body); err != nil {
+		log.Fatal(err)
+	}
+	pool.stopAndWait()
+
+	return true
+}
+
+// newBuildRequest builds the POST /jobs/platform-{platform} request shared
+// by every build path: single-target, cache-aware, and multi-platform.
+//
+// It deliberately doesn't send a client-computed cache key: the server
+// always recomputes its own hash from the archive it actually extracts
+// (see handleJobCreate), so a client-supplied digest would either be
+// redundant or, if ever trusted, a cache-poisoning risk. The real cache
+// fast path is fetchCachedArtifact's pre-flight GET, which can skip the
+// upload entirely on a hit.
+func newBuildRequest(platform string, body io.Reader, size int64, tags []string) (*http.Request, error) {
+	req, err := http.NewRequest("POST", "http://"+*connect+":2222/jobs/platform-"+platform, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Build-Tags", strings.Join(tags, ","))
+	return req, nil
+}
+
+// readJobID reads and closes a job-creation response, returning the job ID.
+func readJobID(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// submitJob uploads body (a gzip'd tar archive) as a new build of platform,
+// reporting upload progress to bar, and returns the job ID the server
+// assigned it.
+func submitJob(platform string, body *bytes.Buffer, bar *progressBar) string {
+	reader := &countingReader{r: bytes.NewReader(body.Bytes()), bar: bar}
+
+	req, err := newBuildRequest(platform, reader, int64(body.Len()), targetTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	id, err := readJobID(resp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
+
+// followJobLog streams a job's build output to w until the job finishes.
+func followJobLog(id string, w io.Writer) error {
+	resp, err := http.Get("http://" + *connect + ":2222/jobs/" + id + "/log")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-		stat, _ := bin.Stat()
-		fmt.Println("Written", bin.Name(), "with", stat.Size(), "bytes")
-		bin.Close()
-	} else {
-		fmt.Println("Unknown Content-Type in response", contentType)
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// fetchJobStatus asks the server for a job's current status and exit code.
+func fetchJobStatus(id string) (string, int, error) {
+	resp, err := http.Get("http://" + *connect + ":2222/jobs/" + id + "/status")
+	if err != nil {
+		return "", 0, err
 	}
+	defer resp.Body.Close()
+
+	var status string
+	var exitCode int
+	fmt.Fscan(resp.Body, &status, &exitCode)
+	return status, exitCode, nil
+}
+
+// downloadJobArtifact fetches a finished job's output.zip into out/<platform>.zip,
+// reporting progress to a bar in pool sized from the response's Content-Length.
+func downloadJobArtifact(id, platform string, pool *barPool) {
+	resp, err := http.Get("http://" + *connect + ":2222/jobs/" + id + "/artifact")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	bar := pool.add("download", resp.ContentLength)
+	body := &countingReader{r: resp.Body, bar: bar}
+
+	os.MkdirAll("out", 0755)
+	bin, err := os.Create("out/" + platform + ".zip")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bin.Close()
+
+	n, err := io.Copy(bin, body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Written", bin.Name(), "with", strconv.FormatInt(n, 10), "bytes")
+}
+
+// downloadJobArtifactPlain fetches a finished job's output.zip into
+// out/<platform>.zip without a progress bar, returning the number of bytes
+// written (0 on failure) for use in the multi-target summary table.
+func downloadJobArtifactPlain(id, platform string) int64 {
+	resp, err := http.Get("http://" + *connect + ":2222/jobs/" + id + "/artifact")
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	os.MkdirAll("out", 0755)
+	bin, err := os.Create("out/" + platform + ".zip")
+	if err != nil {
+		return 0
+	}
+	defer bin.Close()
+
+	n, _ := io.Copy(bin, resp.Body)
+	return n
 }
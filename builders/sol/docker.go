@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// executor runs a platform's compile script against an already-populated
+// source directory, writing its combined stdout/stderr to out.
+type executor interface {
+	compile(ctx context.Context, dir, platform string, out io.Writer) error
+}
+
+// buildExecutor is selected once at startup via --executor.
+var buildExecutor executor = hostExecutor{}
+
+// hostExecutor runs compile directly on the server, as before.
+type hostExecutor struct{}
+
+func (hostExecutor) compile(ctx context.Context, dir, platform string, out io.Writer) error {
+	cmd := exec.CommandContext(ctx, initialDir+"/"+platform+"/compile")
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// platformConfig holds the bits of a platform-*/platform.yaml that the
+// docker executor cares about. Any field left empty falls back to the
+// executor's own default.
+type platformConfig struct {
+	image  string
+	cpus   string
+	memory string
+}
+
+// readPlatformConfig parses the (optional) "key: value" lines of
+// platform-*/platform.yaml. It's deliberately not a general YAML parser,
+// just enough for the handful of settings a platform needs to override.
+func readPlatformConfig(platformDir string) platformConfig {
+	var cfg platformConfig
+
+	f, err := os.Open(platformDir + "/platform.yaml")
+	if err != nil {
+		return cfg
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "image":
+			cfg.image = value
+		case "cpus":
+			cfg.cpus = value
+		case "memory":
+			cfg.memory = value
+		}
+	}
+
+	return cfg
+}
+
+// dockerExecutor runs each platform's compile script inside a container,
+// built (and cached) once per platform, with the source bind-mounted
+// read-write at /src and the network disabled by default.
+//
+// This shells out to the docker CLI rather than talking to the Engine API
+// via github.com/docker/docker/client: nothing else in this tree has a
+// go.mod or vendored deps, and this is the only place that would need one.
+// Scope cut deliberately, not an oversight - revisit if/when this tool
+// grows real dependency management.
+type dockerExecutor struct {
+	mu    sync.Mutex
+	built map[string]string // platform -> image tag already built
+
+	defaultCPUs   string
+	defaultMemory string
+	timeout       time.Duration
+}
+
+func newDockerExecutor(cpus, memory string, timeout time.Duration) *dockerExecutor {
+	return &dockerExecutor{
+		built:         make(map[string]string),
+		defaultCPUs:   cpus,
+		defaultMemory: memory,
+		timeout:       timeout,
+	}
+}
+
+// imageFor returns the image tag to run platform's compile script in,
+// building it from the platform's Dockerfile the first time it's needed.
+// Any output from that build is written to out, the same job log the
+// compile step itself streams to, so a client isn't staring at a silent
+// gap in /jobs/{id}/log while the image builds. ctx bounds the build the
+// same way it bounds the compile step itself, so a slow first-per-platform
+// image build is still subject to --docker-timeout and job cancellation.
+func (d *dockerExecutor) imageFor(ctx context.Context, platform string, cfg platformConfig, out io.Writer) (string, error) {
+	if cfg.image != "" {
+		return cfg.image, nil
+	}
+
+	d.mu.Lock()
+	if image, ok := d.built[platform]; ok {
+		d.mu.Unlock()
+		return image, nil
+	}
+	d.mu.Unlock()
+
+	platformDir := initialDir + "/" + platform
+	if _, err := os.Stat(platformDir + "/Dockerfile"); err != nil {
+		return "", fmt.Errorf("platform %s has no platform.yaml image and no Dockerfile", platform)
+	}
+
+	image := "sol-" + platform
+	fmt.Fprintf(out, "building docker image %s ...\n", image)
+	cmd := exec.CommandContext(ctx, "docker", "build", "-t", image, platformDir)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("couldn't build docker image for %s: %s", platform, err)
+	}
+
+	d.mu.Lock()
+	d.built[platform] = image
+	d.mu.Unlock()
+
+	return image, nil
+}
+
+func (d *dockerExecutor) compile(ctx context.Context, dir, platform string, out io.Writer) error {
+	cfg := readPlatformConfig(initialDir + "/" + platform)
+
+	runCtx := ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	image, err := d.imageFor(runCtx, platform, cfg, out)
+	if err != nil {
+		return err
+	}
+
+	cpus := d.defaultCPUs
+	if cfg.cpus != "" {
+		cpus = cfg.cpus
+	}
+	memory := d.defaultMemory
+	if cfg.memory != "" {
+		memory = cfg.memory
+	}
+
+	// Name the container after dir (already a unique per-job temp dir) so
+	// that on cancellation we can ask the daemon to stop it directly,
+	// rather than only killing our local "docker run" client process,
+	// which doesn't reliably stop the container itself.
+	containerName := "sol-" + filepath.Base(dir)
+
+	args := []string{"run", "--rm", "--name", containerName, "-v", dir + ":/src", "-w", "/src", "--network", "none"}
+	if cpus != "" {
+		args = append(args, "--cpus", cpus)
+	}
+	if memory != "" {
+		args = append(args, "--memory", memory)
+	}
+	args = append(args, image, "./compile")
+
+	cmd := exec.CommandContext(runCtx, "docker", args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-runCtx.Done():
+			exec.Command("docker", "kill", containerName).Run()
+		case <-done:
+		}
+	}()
+
+	return cmd.Run()
+}
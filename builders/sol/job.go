@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobSucceeded jobStatus = "succeeded"
+	jobFailed    jobStatus = "failed"
+)
+
+// logBroadcaster fans a single build's stdout/stderr out to any number of
+// watchers, replaying everything written so far to late subscribers.
+type logBroadcaster struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newLogBroadcaster() *logBroadcaster {
+	b := &logBroadcaster{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *logBroadcaster) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	b.buf.Write(p)
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+func (b *logBroadcaster) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// WriteTo streams everything written to b, starting from the beginning,
+// blocking for more output until b is closed or ctx is done.
+func (b *logBroadcaster) WriteTo(ctx context.Context, w io.Writer) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	offset := 0
+	for {
+		b.mu.Lock()
+		for b.buf.Len() <= offset && !b.closed && ctx.Err() == nil {
+			b.cond.Wait()
+		}
+		chunk := append([]byte(nil), b.buf.Bytes()[offset:]...)
+		offset = b.buf.Len()
+		done := b.closed && b.buf.Len() <= offset
+		b.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// job tracks a single queued/running/finished build.
+type job struct {
+	id       string
+	platform string
+	dir      string
+	cacheSHA string // cache key for this job's inputs, empty if caching is off
+	log      *logBroadcaster
+	created  time.Time
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex
+	status   jobStatus
+	exitCode int
+}
+
+func newJobID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func (j *job) setResult(status jobStatus, exitCode int) {
+	j.mu.Lock()
+	j.status = status
+	j.exitCode = exitCode
+	j.mu.Unlock()
+}
+
+func (j *job) snapshot() (jobStatus, int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status, j.exitCode
+}
+
+// jobRegistry keeps track of in-flight and recently finished jobs and runs
+// them on a bounded worker pool.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	work chan *job
+	ttl  time.Duration
+}
+
+// minJobTTL is the smallest --job-ttl newJobRegistry will honor. reapLoop
+// ticks at ttl/2, so anything non-positive would make time.NewTicker panic.
+const minJobTTL = time.Second
+
+func newJobRegistry(concurrency int, ttl time.Duration) *jobRegistry {
+	if ttl < minJobTTL {
+		ttl = minJobTTL
+	}
+
+	r := &jobRegistry{
+		jobs: make(map[string]*job),
+		work: make(chan *job, 64),
+		ttl:  ttl,
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go r.worker()
+	}
+	go r.reapLoop()
+
+	return r
+}
+
+func (r *jobRegistry) submit(platform, dir, cacheSHA string) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		id:       newJobID(),
+		platform: platform,
+		dir:      dir,
+		cacheSHA: cacheSHA,
+		log:      newLogBroadcaster(),
+		created:  time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+		status:   jobQueued,
+	}
+
+	r.mu.Lock()
+	r.jobs[j.id] = j
+	r.mu.Unlock()
+
+	r.work <- j
+	return j
+}
+
+// submitCached registers a job that's already finished because its inputs
+// matched a build cache entry, so watchers of /jobs/{id}/* see a consistent
+// succeeded/artifact pair without a compile ever running.
+func (r *jobRegistry) submitCached(platform, dir string) *job {
+	j := &job{
+		id:       newJobID(),
+		platform: platform,
+		dir:      dir,
+		log:      newLogBroadcaster(),
+		created:  time.Now(),
+		ctx:      context.Background(),
+		cancel:   func() {},
+		status:   jobSucceeded,
+	}
+	j.log.Write([]byte("cache hit, reusing previous build\n"))
+	j.log.Close()
+
+	r.mu.Lock()
+	r.jobs[j.id] = j
+	r.mu.Unlock()
+
+	return j
+}
+
+func (r *jobRegistry) get(id string) (*job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+func (r *jobRegistry) cancel(id string) bool {
+	j, ok := r.get(id)
+	if !ok {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+func (r *jobRegistry) worker() {
+	for j := range r.work {
+		r.runJob(j)
+	}
+}
+
+func (r *jobRegistry) runJob(j *job) {
+	if j.ctx.Err() != nil {
+		j.log.Close()
+		j.setResult(jobFailed, -1)
+		return
+	}
+
+	j.setResult(jobRunning, 0)
+
+	err := buildExecutor.compile(j.ctx, j.dir, j.platform, j.log)
+	j.log.Close()
+
+	if err != nil {
+		exitCode := -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+		j.setResult(jobFailed, exitCode)
+		return
+	}
+
+	if j.cacheSHA != "" {
+		if err := cache.store(j.cacheSHA, j.dir+"/output.zip"); err != nil {
+			fmt.Println("couldn't store build in cache:", err)
+		}
+	}
+
+	j.setResult(jobSucceeded, 0)
+}
+
+// reapLoop periodically deletes finished jobs (and their temp dirs) once
+// they're older than the registry's TTL.
+func (r *jobRegistry) reapLoop() {
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		r.mu.Lock()
+		var expired []*job
+		for id, j := range r.jobs {
+			status, _ := j.snapshot()
+			if status != jobQueued && status != jobRunning && now.Sub(j.created) > r.ttl {
+				expired = append(expired, j)
+				delete(r.jobs, id)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, j := range expired {
+			os.RemoveAll(j.dir)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// handleJobs dispatches the various /jobs/... endpoints: POST /jobs/{platform}
+// to start a build, and GET/DELETE /jobs/{id}[/log|/status|/artifact] to
+// follow or manage one already in flight.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	parts := strings.SplitN(path, "/", 2)
+
+	if r.Method == http.MethodPost {
+		handleJobCreate(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method == http.MethodDelete {
+			handleJobCancel(w, parts[0])
+			return
+		}
+		clientError(w, "unsupported method %s for /jobs/%s", r.Method, parts[0])
+		return
+	}
+
+	id, action := parts[0], parts[1]
+	switch action {
+	case "log":
+		handleJobLog(w, r, id)
+	case "status":
+		handleJobStatus(w, id)
+	case "artifact":
+		handleJobArtifact(w, id)
+	default:
+		clientError(w, "unknown job endpoint %q", action)
+	}
+}
+
+func handleJobCreate(w http.ResponseWriter, r *http.Request, plat string) {
+	if !platformExists(plat) {
+		clientError(w, "%s is not available", plat)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "sb-")
+	if err != nil {
+		internalError(w, "couldn't create tempdir: %s", err)
+		return
+	}
+
+	limits := extractLimits{
+		maxFileBytes:  *maxArchiveFileBytes,
+		maxTotalBytes: *maxArchiveTotalBytes,
+	}
+	entries, err := extractArchive(r.Body, dir, limits)
+	if err != nil {
+		os.RemoveAll(dir)
+		clientError(w, "couldn't extract archive: %s", err)
+		return
+	}
+
+	tags := strings.FieldsFunc(r.Header.Get("X-Build-Tags"), func(r rune) bool { return r == ',' })
+	sha := hashEntries(entries, plat, tags)
+
+	if artifact, ok := cache.lookup(sha); ok {
+		os.RemoveAll(dir)
+		cachedDir, err := ioutil.TempDir("", "sb-")
+		if err != nil {
+			internalError(w, "couldn't create tempdir: %s", err)
+			return
+		}
+		if err := copyFile(artifact, cachedDir+"/output.zip"); err != nil {
+			os.RemoveAll(cachedDir)
+			internalError(w, "couldn't reuse cached artifact: %s", err)
+			return
+		}
+
+		j := jobs.submitCached(plat, cachedDir)
+		fmt.Fprintln(w, j.id)
+		return
+	}
+
+	j := jobs.submit(plat, dir, sha)
+	fmt.Fprintln(w, j.id)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func lookupJob(w http.ResponseWriter, id string) *job {
+	j, ok := jobs.get(id)
+	if !ok {
+		clientError(w, "no such job %q", id)
+		return nil
+	}
+	return j
+}
+
+func handleJobLog(w http.ResponseWriter, r *http.Request, id string) {
+	j := lookupJob(w, id)
+	if j == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	j.log.WriteTo(r.Context(), w)
+}
+
+func handleJobStatus(w http.ResponseWriter, id string) {
+	j := lookupJob(w, id)
+	if j == nil {
+		return
+	}
+
+	status, exitCode := j.snapshot()
+	fmt.Fprintf(w, "%s %d\n", status, exitCode)
+}
+
+func handleJobArtifact(w http.ResponseWriter, id string) {
+	j := lookupJob(w, id)
+	if j == nil {
+		return
+	}
+
+	status, _ := j.snapshot()
+	if status != jobSucceeded {
+		clientError(w, "job %q hasn't succeeded (status: %s)", id, status)
+		return
+	}
+
+	bin, err := os.Open(j.dir + "/output.zip")
+	if err != nil {
+		internalError(w, "couldn't open artifact: %s", err)
+		return
+	}
+	defer bin.Close()
+
+	stat, _ := bin.Stat()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	io.Copy(w, bin)
+}
+
+func handleJobCancel(w http.ResponseWriter, id string) {
+	if !jobs.cancel(id) {
+		clientError(w, "no such job %q", id)
+		return
+	}
+	fmt.Fprintln(w, "cancelled")
+}
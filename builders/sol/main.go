@@ -3,10 +3,23 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 )
 
 var runAsServer = flag.Bool("run-as-server", false, "Run as build server")
 var connect = flag.String("connect", "localhost", "Server to connect")
+var maxArchiveFileBytes = flag.Int64("max-archive-file-bytes", 128<<20, "maximum size of a single file accepted in an uploaded archive")
+var maxArchiveTotalBytes = flag.Int64("max-archive-total-bytes", 512<<20, "maximum total extracted size accepted from an uploaded archive")
+var jobConcurrency = flag.Int("job-concurrency", 2, "number of builds to run at once")
+var jobTTL = flag.Duration("job-ttl", 30*time.Minute, "how long a finished job (and its temp dir) is kept around before being reaped")
+var executorFlag = flag.String("executor", "host", "how to run platform compile scripts: \"host\" or \"docker\"")
+var dockerCPUs = flag.String("docker-cpus", "", "CPU limit passed to docker run (e.g. \"2\"); empty means no limit")
+var dockerMemory = flag.String("docker-memory", "", "memory limit passed to docker run (e.g. \"2g\"); empty means no limit")
+var dockerTimeout = flag.Duration("docker-timeout", 10*time.Minute, "max time a docker-backed compile may run before being killed")
+var cacheDir = flag.String("cache-dir", "", "directory to cache build artifacts in, keyed by input hash; empty disables the cache")
+var cacheMaxBytes = flag.Int64("cache-max-bytes", 10<<30, "evict least-recently-used cache entries once the cache exceeds this size")
+var buildAll = flag.Bool("all", false, "build every available platform")
+var parallelBuilds = flag.Int("parallel", 4, "number of concurrent builds to run when targeting multiple platforms")
 var initialDir string
 
 // TODO: more info on the output, correct file name for output, automatically zip?
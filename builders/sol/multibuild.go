@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// buildOutcome is one platform's result from a multi-platform build, enough
+// to render the summary table runMultiBuild prints at the end.
+type buildOutcome struct {
+	platform     string
+	status       string
+	exitCode     int
+	duration     time.Duration
+	artifactSize int64
+}
+
+// runMultiBuild fans a single shared archive out to several platforms at
+// once, bounded by --parallel, collecting each into out/<platform>.zip and
+// out/<platform>.log, then prints a summary and exits non-zero if any
+// target failed.
+func runMultiBuild(platforms []string, tags []string) {
+	files := pickFilesForTargets(platforms, tags)
+	fmt.Println("Files picked:", files)
+
+	var buf bytes.Buffer
+	if _, err := writeArchive(files, &buf); err != nil {
+		log.Fatal("Couldn't archive the files")
+	}
+	archive := buf.Bytes()
+
+	os.MkdirAll("out", 0755)
+
+	outcomes := make([]buildOutcome, len(platforms))
+	sem := make(chan struct{}, *parallelBuilds)
+	var wg sync.WaitGroup
+
+	for i, platform := range platforms {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, platform string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			outcomes[i] = buildOne(platform, tags, archive)
+		}(i, platform)
+	}
+	wg.Wait()
+
+	printBuildSummary(outcomes)
+
+	for _, o := range outcomes {
+		if o.status != string(jobSucceeded) {
+			os.Exit(1)
+		}
+	}
+}
+
+// buildOne runs a single platform's build to completion against an
+// already-built archive, logging to out/<platform>.log and downloading
+// out/<platform>.zip on success.
+func buildOne(platform string, tags []string, archive []byte) buildOutcome {
+	start := time.Now()
+
+	req, err := newBuildRequest(platform, bytes.NewReader(archive), int64(len(archive)), tags)
+	if err != nil {
+		fmt.Println(platform, "couldn't build request:", err)
+		return buildOutcome{platform: platform, status: "error", duration: time.Since(start)}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println(platform, "couldn't submit job:", err)
+		return buildOutcome{platform: platform, status: "error", duration: time.Since(start)}
+	}
+
+	id, err := readJobID(resp)
+	if err != nil {
+		fmt.Println(platform, "couldn't read job id:", err)
+		return buildOutcome{platform: platform, status: "error", duration: time.Since(start)}
+	}
+
+	if logFile, err := os.Create("out/" + platform + ".log"); err == nil {
+		if err := followJobLog(id, logFile); err != nil {
+			fmt.Println(platform, "couldn't stream log:", err)
+		}
+		logFile.Close()
+	}
+
+	status, exitCode, err := fetchJobStatus(id)
+	if err != nil {
+		fmt.Println(platform, "couldn't fetch status:", err)
+		return buildOutcome{platform: platform, status: "error", duration: time.Since(start)}
+	}
+
+	var artifactSize int64
+	if status == string(jobSucceeded) {
+		artifactSize = downloadJobArtifactPlain(id, platform)
+	}
+
+	return buildOutcome{
+		platform:     platform,
+		status:       status,
+		exitCode:     exitCode,
+		duration:     time.Since(start),
+		artifactSize: artifactSize,
+	}
+}
+
+func printBuildSummary(outcomes []buildOutcome) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLATFORM\tDURATION\tSTATUS\tARTIFACT")
+	for _, o := range outcomes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", o.platform, o.duration.Round(time.Millisecond), o.status, humanBytes(o.artifactSize))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressBar tracks bytes transferred against an optional known total.
+// A total of 0 means "unknown" and the bar just reports bytes seen so far.
+type progressBar struct {
+	label   string
+	total   int64
+	current int64
+}
+
+func (p *progressBar) add(n int64) {
+	atomic.AddInt64(&p.current, n)
+}
+
+func (p *progressBar) render() string {
+	current := atomic.LoadInt64(&p.current)
+	if p.total <= 0 {
+		return fmt.Sprintf("%-10s %s", p.label, humanBytes(current))
+	}
+
+	pct := float64(current) / float64(p.total) * 100
+	if pct > 100 {
+		pct = 100
+	}
+
+	const width = 30
+	filled := int(float64(width) * pct / 100)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	return fmt.Sprintf("%-10s [%s] %6.2f%% (%s/%s)", p.label, bar, pct, humanBytes(current), humanBytes(p.total))
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// barPool renders a handful of progress bars, one per line. On a TTY it
+// redraws them in place; otherwise (e.g. CI) it just prints plain lines
+// every tick.
+type barPool struct {
+	mu   sync.Mutex
+	bars []*progressBar
+	tty  bool
+
+	linesDrawn int
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func newBarPool() *barPool {
+	fi, err := os.Stdout.Stat()
+	tty := err == nil && fi.Mode()&os.ModeCharDevice != 0
+
+	p := &barPool{tty: tty, stop: make(chan struct{}), done: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+func (p *barPool) add(label string, total int64) *progressBar {
+	bar := &progressBar{label: label, total: total}
+	p.mu.Lock()
+	p.bars = append(p.bars, bar)
+	p.mu.Unlock()
+	return bar
+}
+
+func (p *barPool) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.draw()
+		case <-p.stop:
+			p.draw()
+			return
+		}
+	}
+}
+
+func (p *barPool) draw() {
+	p.mu.Lock()
+	lines := make([]string, len(p.bars))
+	for i, b := range p.bars {
+		lines[i] = b.render()
+	}
+	p.mu.Unlock()
+
+	if p.tty {
+		if p.linesDrawn > 0 {
+			fmt.Fprintf(os.Stdout, "\033[%dA", p.linesDrawn)
+		}
+		for _, l := range lines {
+			fmt.Fprintf(os.Stdout, "\033[2K%s\n", l)
+		}
+		p.linesDrawn = len(lines)
+	} else {
+		for _, l := range lines {
+			fmt.Fprintln(os.Stdout, l)
+		}
+	}
+}
+
+// stopAndWait draws a final frame and stops the refresh goroutine.
+func (p *barPool) stopAndWait() {
+	close(p.stop)
+	<-p.done
+}
+
+// countingReader wraps an io.Reader, reporting every byte read to a
+// progressBar; used to track upload/download progress from the actual
+// bytes that cross the wire rather than by polling file size.
+type countingReader struct {
+	r   io.Reader
+	bar *progressBar
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.bar.add(int64(n))
+	return n, err
+}
@@ -1,27 +1,39 @@
 package main
 
 import (
-	"archive/tar"
-	"bytes"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
 	"strings"
 )
 
 // TODO: HERE: improving error messages
 // TODO: HERE: normalize with zip output?
 
+var jobs *jobRegistry
+var cache *buildCache
+
 func runServer() {
 	addr := ":2222"
+
+	switch *executorFlag {
+	case "docker":
+		buildExecutor = newDockerExecutor(*dockerCPUs, *dockerMemory, *dockerTimeout)
+	case "host":
+		buildExecutor = hostExecutor{}
+	default:
+		log.Fatalf("unknown --executor %q, want \"host\" or \"docker\"", *executorFlag)
+	}
+
+	jobs = newJobRegistry(*jobConcurrency, *jobTTL)
+	cache = newBuildCache(*cacheDir, *cacheMaxBytes)
+
 	fmt.Println("Listening at", addr)
-	http.HandleFunc("/build/", handleBuild)
+	http.HandleFunc("/jobs/", handleJobs)
 	http.HandleFunc("/list", handleList)
+	http.HandleFunc("/cache/stats", handleCacheStats)
+	http.HandleFunc("/cache/", handleCache)
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
 
@@ -69,76 +81,3 @@ func internalError(w http.ResponseWriter, format string, a ...interface{}) {
 	fmt.Println()
 	fmt.Fprintln(w, "Internal error")
 }
-
-func compile(dir string, platform string) (*bytes.Buffer, error) {
-	var out bytes.Buffer
-	mw := io.MultiWriter(&out, os.Stdout)
-
-	// TODO: check permission denied error
-
-	cmd := exec.Command(initialDir + "/" + platform + "/compile")
-	cmd.Dir = dir
-	cmd.Stdout = mw
-	cmd.Stderr = mw
-
-	return &out, cmd.Run()
-}
-
-func handleBuild(w http.ResponseWriter, r *http.Request) {
-	plat := r.URL.Path[7:]
-
-	if !platformExists(plat) {
-		clientError(w, "%s is not available", plat)
-		return
-	}
-
-	dir, err := ioutil.TempDir("", "sb-")
-	if err != nil {
-		internalError(w, "couldn't create tempdir: %s", err)
-		return
-	}
-
-	defer os.RemoveAll(dir)
-
-	tr := tar.NewReader(r.Body)
-
-	header, _ := tr.Next()
-	for ; header != nil; header, _ = tr.Next() {
-		switch header.Typeflag {
-		case tar.TypeDir:
-			os.MkdirAll(dir+"/"+header.Name, 0755)
-
-		case tar.TypeReg:
-			f, err := os.Create(dir + "/" + header.Name)
-			if err != nil {
-				log.Fatal(err)
-			}
-			io.Copy(f, tr)
-			f.Close()
-		}
-	}
-
-	out, err := compile(dir, plat)
-
-	if err != nil {
-		if out.Bytes() != nil {
-			clientError(w, "Compilation error\n")
-			io.Copy(w, out)
-		} else {
-			internalError(w, "couldn't call compile: %s", err)
-		}
-	} else {
-		bin, err := os.Open(dir + "/output.zip")
-		if err != nil {
-			// TODO: set HTTP header.
-			fmt.Fprintf(w, "Internal error: %s\n", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/octet-stream")
-		stat, _ := bin.Stat()
-		fmt.Println(strconv.FormatInt(stat.Size(), 10))
-		io.Copy(os.Stdout, out)
-		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
-		io.Copy(w, bin)
-	}
-}